@@ -1,13 +1,16 @@
 package csv
 
 import (
+	"encoding"
 	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
+	"unicode"
 )
 
 var (
@@ -17,8 +20,59 @@ var (
 	ErrUnwritable = errors.New("csv2: writers accept struct or struct slices")
 )
 
-// GetFieldNames returns a string array of the given interface's field names
-// if the given interface is a struct or slice of structs
+// CSVUnmarshaler is implemented by types that can parse themselves from a
+// single CSV field. It takes precedence over the built-in kind-based
+// parsing in setValue.
+type CSVUnmarshaler interface {
+	UnmarshalCSV(string) error
+}
+
+// CSVMarshaler is implemented by types that can format themselves as a
+// single CSV field. It takes precedence over the built-in kind-based
+// formatting in getStrings.
+type CSVMarshaler interface {
+	MarshalCSV() (string, error)
+}
+
+// unmarshalField delegates parsing raw to f if f implements CSVUnmarshaler
+// or, failing that, encoding.TextUnmarshaler. handled is false if neither
+// interface is implemented, in which case the caller should fall back to
+// kind-based parsing.
+func unmarshalField(f reflect.Value, raw string) (handled bool, err error) {
+	if !f.CanAddr() {
+		return false, nil
+	}
+	switch u := f.Addr().Interface().(type) {
+	case CSVUnmarshaler:
+		return true, u.UnmarshalCSV(raw)
+	case encoding.TextUnmarshaler:
+		return true, u.UnmarshalText([]byte(raw))
+	}
+	return false, nil
+}
+
+// marshalField delegates formatting f to CSVMarshaler or, failing that,
+// encoding.TextMarshaler. handled is false if neither interface is
+// implemented, in which case the caller should fall back to kind-based
+// formatting.
+func marshalField(f reflect.Value) (s string, handled bool, err error) {
+	target := f.Interface()
+	if f.CanAddr() {
+		target = f.Addr().Interface()
+	}
+	switch m := target.(type) {
+	case CSVMarshaler:
+		s, err = m.MarshalCSV()
+		return s, true, err
+	case encoding.TextMarshaler:
+		b, err := m.MarshalText()
+		return string(b), true, err
+	}
+	return "", false, nil
+}
+
+// GetFieldNames returns a string array of the given interface's (flattened)
+// field names if the given interface is a struct or slice of structs
 func GetFieldNames(i interface{}) ([]string, error) {
 	// Given interface must be a struct or a slice of structs
 	// TODO Pointers!?
@@ -38,32 +92,208 @@ func GetFieldNames(i interface{}) ([]string, error) {
 	default:
 		return nil, ErrUnwritable
 	}
-	// Get the names of the struct fields
-	fields := make([]string, elem.NumField())
-	for index := 0; index < elem.NumField(); index += 1 {
-		fields[index] = elem.Field(index).Name
+
+	info := buildFieldInfo(elem)
+	names := make([]string, len(info))
+	for i, f := range info {
+		names[i] = f.Name
 	}
-	return fields, nil
+	return names, nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// fieldInfo describes how a single CSV column binds to a struct field,
+// possibly nested inside an embedded or "inline" struct field.
+type fieldInfo struct {
+	Index     []int  // reflect field index path, suitable for FieldByIndex
+	Name      string // the CSV column name this field binds to
+	Layout    string // time.Parse layout, set only for time.Time fields
+	OmitEmpty bool   // from the "omitempty" tag option
 }
 
-// setLayout checks the given struct type for any "csv" tags.
-// This layout is used for alternative parse formats.
-func setLayout(v reflect.Type) map[int]string {
-	layout := make(map[int]string)
-	for i := 0; i < v.NumField(); i += 1 {
-		f := v.Field(i)
-		tag := f.Tag.Get("csv")
-		if tag != "" {
-			layout[i] = tag
+// parseCSVTag splits a "csv" struct tag into its name (the part before the
+// first comma) and its comma-separated options, e.g. `csv:"addr,inline"`
+// yields ("addr", {"inline": true}).
+func parseCSVTag(tag string) (name string, opts map[string]bool) {
+	if tag == "" {
+		return "", nil
+	}
+	parts := strings.Split(tag, ",")
+	opts = make(map[string]bool, len(parts)-1)
+	for _, opt := range parts[1:] {
+		opts[opt] = true
+	}
+	return parts[0], opts
+}
+
+// buildFieldInfo walks the fields of the given struct type and returns the
+// flattened list of CSV columns in order. Anonymous (embedded) struct
+// fields are expanded automatically; a named struct field tagged
+// `csv:",inline"` (or `csv:"prefix,inline"` to prefix its flattened column
+// names) is expanded the same way. A field's "csv" tag is used as its
+// column name unless the field is a time.Time, whose tag is reserved for
+// the time.Parse layout; in that case the field's own name is used for
+// matching instead. A trailing ",omitempty" option marks the field for
+// NullString handling on read and write; see Reader.NullString and
+// Writer.NullString.
+func buildFieldInfo(t reflect.Type) []fieldInfo {
+	return appendFieldInfo(nil, t, "", nil)
+}
+
+func appendFieldInfo(info []fieldInfo, t reflect.Type, namePrefix string, indexPrefix []int) []fieldInfo {
+	for i := 0; i < t.NumField(); i += 1 {
+		f := t.Field(i)
+		index := append(append([]int{}, indexPrefix...), i)
+		name, opts := parseCSVTag(f.Tag.Get("csv"))
+
+		if f.Type == timeType {
+			// The whole tag is the layout, which may itself contain
+			// commas; only a trailing ",omitempty" is taken as an option.
+			layout := f.Tag.Get("csv")
+			info = append(info, fieldInfo{
+				Index:     index,
+				Name:      namePrefix + f.Name,
+				Layout:    strings.TrimSuffix(layout, ",omitempty"),
+				OmitEmpty: strings.HasSuffix(layout, ",omitempty"),
+			})
+			continue
+		}
+
+		// Determine the struct type to recurse into, if any
+		structType := f.Type
+		if structType.Kind() == reflect.Ptr {
+			structType = structType.Elem()
+		}
+		if structType.Kind() == reflect.Struct && (f.Anonymous || opts["inline"]) {
+			prefix := namePrefix + name
+			info = appendFieldInfo(info, structType, prefix, index)
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		info = append(info, fieldInfo{
+			Index:     index,
+			Name:      namePrefix + name,
+			OmitEmpty: opts["omitempty"],
+		})
+	}
+	return info
+}
+
+// defaultHeaderNormalizer strips non-alphanumeric characters and
+// lowercases name, so that headers like "GDP (trillions)" or "FREEDOM?"
+// compare equal to field names or tags that differ only in case and
+// punctuation.
+func defaultHeaderNormalizer(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
+
+// mapColumns matches each CSV header against the given field info using
+// r.MatchHeader, or r.HeaderNormalizer if no MatchHeader is set, and
+// returns, for each column, the index into info of the field it should
+// populate (or -1 if no field matches). Headers and struct fields that
+// could not be matched are also returned so callers can diagnose
+// misaligned CSVs.
+func (r *Reader) mapColumns(headers []string, info []fieldInfo) (fieldIndex []int, mismatchedHeaders, mismatchedStructFields []string) {
+	match := r.MatchHeader
+	if match == nil {
+		normalize := r.HeaderNormalizer
+		if normalize == nil {
+			normalize = defaultHeaderNormalizer
+		}
+		match = func(csvCol, structField string) bool {
+			return normalize(csvCol) == normalize(structField)
 		}
 	}
-	return layout
+
+	fieldIndex = make([]int, len(headers))
+	matched := make([]bool, len(info))
+	for col, header := range headers {
+		fieldIndex[col] = -1
+		for i, f := range info {
+			if matched[i] {
+				continue
+			}
+			if match(header, f.Name) {
+				fieldIndex[col] = i
+				matched[i] = true
+				break
+			}
+		}
+		if fieldIndex[col] == -1 {
+			mismatchedHeaders = append(mismatchedHeaders, header)
+		}
+	}
+	for i, f := range info {
+		if !matched[i] {
+			mismatchedStructFields = append(mismatchedStructFields, f.Name)
+		}
+	}
+	return
 }
 
 // Reader wraps the csv.Reader and adds a map of csv struct tags.
 type Reader struct {
 	*csv.Reader
-	layout map[int]string
+
+	fields     []fieldInfo // the flattened field tree for the current struct type
+	fieldIndex []int       // column -> index into fields, or -1 if unmatched
+
+	headers []string
+
+	// HeaderNormalizer is applied to both CSV headers and struct field
+	// names/tags before matching, so that e.g. "GDP (trillions)" binds to
+	// a field tagged `csv:"GDP (trillions)"`. It defaults to stripping
+	// non-alphanumeric characters and lowercasing; set by NewReader.
+	HeaderNormalizer func(string) string
+
+	// MatchHeader, if set, overrides HeaderNormalizer entirely and decides
+	// whether a CSV column header matches a struct field's name or tag.
+	// Use it for matching rules HeaderNormalizer cannot express.
+	MatchHeader func(csvCol, structField string) bool
+
+	// NullString is the cell value treated as an absent value: a pointer
+	// field set to it is left nil, and an "omitempty" field set to it
+	// skips parsing and keeps its zero value instead of erroring. It
+	// defaults to the empty string, but may be set to a convention such as
+	// `\N` or `NULL`.
+	NullString string
+
+	// MismatchedHeaders holds the CSV headers that could not be matched to
+	// a struct field, populated during Unmarshal/UnmarshalOne.
+	MismatchedHeaders []string
+
+	// MismatchedStructFields holds the struct fields that could not be
+	// matched to a CSV header, populated during Unmarshal/UnmarshalOne.
+	MismatchedStructFields []string
+}
+
+// Headers returns the header row this Reader has read. The header row is
+// read once, by NewDecoder or by the first Unmarshal or UnmarshalOne call.
+func (r *Reader) Headers() []string {
+	return r.headers
+}
+
+// readHeader reads the next record as the header row and builds the
+// column-index to field-index map for the given struct type.
+func (r *Reader) readHeader(t reflect.Type) error {
+	record, err := r.Read()
+	if err != nil {
+		return err
+	}
+	r.headers = record
+	r.fields = buildFieldInfo(t)
+	r.fieldIndex, r.MismatchedHeaders, r.MismatchedStructFields = r.mapColumns(record, r.fields)
+	return nil
 }
 
 // Unmarshal reads the entire Reader into the given destination.
@@ -85,9 +315,13 @@ func (r *Reader) Unmarshal(i interface{}) error {
 		return ErrNotSlice
 	}
 
-	// Check the struct tags for any custom csv layout tags
-	// TODO Check if already set?
-	r.layout = setLayout(elem)
+	// The first record is always the header row; skip re-reading it if
+	// this Reader has already been used for an earlier Unmarshal call.
+	if r.headers == nil {
+		if err := r.readHeader(elem); err != nil {
+			return err
+		}
+	}
 
 	// Read all
 	for {
@@ -110,11 +344,11 @@ func (r *Reader) Unmarshal(i interface{}) error {
 
 		sliceValue.Set(reflect.Append(sliceValue, newElem))
 	}
-	return nil
 }
 
 // UnmarshalOne reads a single row of the Reader into the given struct.
-// The destination interface must of pointer of type struct.
+// The destination interface must of pointer of type struct. The header row
+// is read once and cached the first time UnmarshalOne is called.
 func (r *Reader) UnmarshalOne(i interface{}) error {
 	// Get the value of the given interface
 	value := reflect.ValueOf(i)
@@ -127,93 +361,243 @@ func (r *Reader) UnmarshalOne(i interface{}) error {
 		return ErrNotStruct
 	}
 
+	if r.headers == nil {
+		if err := r.readHeader(elem.Type()); err != nil {
+			return err
+		}
+	}
+
 	// Get the next record from the reader
 	record, err := r.Read()
 	if err != nil {
 		return err
 	}
-
-	// Get the type of the interface to check for layouts
-	t := reflect.TypeOf(i)
-	r.layout = setLayout(t.Elem())
 	return r.setValue(record, &elem)
 }
 
+// fieldByIndexAlloc walks index through v as reflect.Value.FieldByIndex
+// does, except that a nil pointer to an embedded or "inline" struct
+// (appendFieldInfo allows flattening through *T the same as T) is
+// allocated in place instead of panicking. v must be addressable.
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// fieldByIndex walks index through v as reflect.Value.FieldByIndex does,
+// except that passing through a nil pointer to an embedded or "inline"
+// struct is reported as ok == false instead of panicking, so the caller
+// can treat the fields it would have held as absent.
+func fieldByIndex(v reflect.Value, index []int) (result reflect.Value, ok bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
 // Set the values of the given struct with the reflect package.
-// Fields are processed in sequential order.
+// Columns are mapped to fields through r.fieldIndex, as built from the
+// header row by readHeader.
 func (r *Reader) setValue(values []string, elem *reflect.Value) error {
 	// TODO wrap the errors with the current field
-	for i := 0; i < elem.NumField(); i += 1 {
-		f := elem.Field(i)
+	for col, raw := range values {
+		if r.fieldIndex == nil || col >= len(r.fieldIndex) || r.fieldIndex[col] == -1 {
+			// No struct field matches this column
+			continue
+		}
+		field := r.fields[r.fieldIndex[col]]
+
+		f := fieldByIndexAlloc(*elem, field.Index)
 		if !f.IsValid() || !f.CanSet() {
-			return fmt.Errorf("csv2: field %d cannot be set", i)
+			return fmt.Errorf("csv2: field %s cannot be set", field.Name)
 		}
 
-		// TODO What about using a type switch instead? benchmark it.
-		switch f.Kind() {
-		case reflect.String:
-			f.SetString(values[i])
-		case reflect.Int64:
-			// Attempt to convert the value to an int64
-			v, err := strconv.ParseInt(values[i], 10, 64)
-			if err != nil {
-				return err
+		if f.Kind() == reflect.Ptr {
+			// A null cell leaves the pointer nil; anything else is
+			// parsed into a new value of the pointed-to type.
+			if raw == r.NullString {
+				continue
 			}
-			f.SetInt(v)
-		case reflect.Float64:
-			// Attempt to convert the value to a float64
-			v, err := strconv.ParseFloat(values[i], 64)
-			if err != nil {
+			ptr := reflect.New(f.Type().Elem())
+			if err := r.setScalar(ptr.Elem(), raw, field); err != nil {
 				return err
 			}
-			f.SetFloat(v)
-		case reflect.Bool:
-			// Attempt to convert the value to a boolean
-			v, err := strconv.ParseBool(values[i])
+			f.Set(ptr)
+			continue
+		}
+
+		if field.OmitEmpty && raw == r.NullString {
+			// Leave the field at its zero value instead of failing to
+			// parse a null cell.
+			continue
+		}
+
+		if err := r.setScalar(f, raw, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setScalar parses raw into f, which must be addressable and non-pointer.
+// It is used directly for ordinary fields and against a freshly allocated
+// element when f belongs to a pointer field.
+func (r *Reader) setScalar(f reflect.Value, raw string, field fieldInfo) error {
+	// time.Time is excluded here because it implements TextUnmarshaler
+	// itself (RFC3339 only), which would make field.Layout dead code.
+	if f.Type() != timeType {
+		if handled, err := unmarshalField(f, raw); handled {
+			return err
+		}
+	}
+
+	// TODO What about using a type switch instead? benchmark it.
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(raw)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// Attempt to convert the value to an integer of the field's
+		// own bit size
+		v, err := strconv.ParseInt(raw, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		// Attempt to convert the value to an unsigned integer of the
+		// field's own bit size
+		v, err := strconv.ParseUint(raw, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(v)
+	case reflect.Float32, reflect.Float64:
+		// Attempt to convert the value to a float of the field's own
+		// bit size
+		v, err := strconv.ParseFloat(raw, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(v)
+	case reflect.Bool:
+		// Attempt to convert the value to a boolean
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		f.SetBool(v)
+	case reflect.Struct:
+		switch f.Interface().(type) {
+		case time.Time:
+			// Check if an alternative layout should be used
+			layout := field.Layout
+			if layout == "" {
+				layout = time.RFC3339
+			}
+			parsed, err := time.Parse(layout, raw)
+
 			if err != nil {
 				return err
 			}
-			f.SetBool(v)
-		case reflect.Struct:
-			switch f.Interface().(type) {
-			case time.Time:
-				// Check if an alternative layout should be used
-				layout := r.layout[i]
-				if layout == "" {
-					layout = time.RFC3339
-				}
-				parsed, err := time.Parse(layout, values[i])
-
-				if err != nil {
-					return err
-				}
-				f.Set(reflect.ValueOf(parsed))
-			default:
-				return fmt.Errorf(
-					"csv2: unknown destination struct for field %d",
-					i,
-				)
-			}
+			f.Set(reflect.ValueOf(parsed))
 		default:
 			return fmt.Errorf(
-				"csv2: unsupported type %s for field %d",
-				f.Kind(),
-				i,
+				"csv2: unknown destination struct for field %s",
+				field.Name,
 			)
 		}
+	default:
+		return fmt.Errorf(
+			"csv2: unsupported type %s for field %s",
+			f.Kind(),
+			field.Name,
+		)
 	}
 	return nil
 }
 
 // NewReader returns a new csv2 Reader by wrapping a csv Reader.
 func NewReader(r io.Reader) *Reader {
-	return &Reader{Reader: csv.NewReader(r)}
+	return &Reader{Reader: csv.NewReader(r), HeaderNormalizer: defaultHeaderNormalizer}
+}
+
+// Decoder reads CSV rows into values of a single, pre-determined struct
+// type. Unlike Unmarshal, it reads one row at a time and caches the
+// reflected field info and header mapping across calls, so it can stream
+// through CSVs too large to hold in memory.
+type Decoder struct {
+	r      *Reader
+	record []string
+}
+
+// NewDecoder returns a Decoder that reads rows from r into values shaped
+// like sample. sample is only used to determine the destination struct
+// type; its value is otherwise ignored. The header row is read
+// immediately.
+func NewDecoder(r *Reader, sample interface{}) (*Decoder, error) {
+	t := reflect.TypeOf(sample)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, ErrNotStruct
+	}
+
+	if err := r.readHeader(t); err != nil {
+		return nil, err
+	}
+	return &Decoder{r: r}, nil
+}
+
+// Decode reads the next row into v, which must be a pointer to a struct of
+// the type given to NewDecoder. It returns io.EOF once the underlying
+// Reader is exhausted.
+func (d *Decoder) Decode(v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr {
+		return ErrNotPointer
+	}
+	elem := value.Elem()
+	if elem.Kind() != reflect.Struct {
+		return ErrNotStruct
+	}
+
+	record, err := d.r.Read()
+	if err != nil {
+		return err
+	}
+	d.record = record
+	return d.r.setValue(record, &elem)
+}
+
+// Record returns the raw CSV row read by the most recent Decode call.
+func (d *Decoder) Record() []string {
+	return d.record
 }
 
 // Writer wraps the csv.Writer and adds a map of csv struct tags.
 type Writer struct {
 	*csv.Writer
-	layout map[int]string
+	fields []fieldInfo // the flattened field tree for the current struct type
+
+	// NullString is the value written for a nil pointer field, or for an
+	// "omitempty" field holding its zero value. It defaults to the empty
+	// string, but may be set to a convention such as `\N` or `NULL`.
+	NullString string
 }
 
 // WriteHeader will write the names of the underlying struct fields as a row.
@@ -229,51 +613,94 @@ func (w *Writer) WriteHeader(i interface{}) error {
 	return nil
 }
 
-func (w *Writer) getStrings(elem reflect.Value) ([]string, error) {
-	output := make([]string, elem.NumField())
-	for i := 0; i < elem.NumField(); i += 1 {
-		f := elem.Field(i)
-
-		// TODO What about using a type switch instead? benchmark it.
-		switch f.Kind() {
-		case reflect.String:
-			output[i] = f.String()
-		case reflect.Int64:
-			// TODO additional base output
-			output[i] = strconv.FormatInt(f.Int(), 10)
-		case reflect.Float64:
-			// TODO additional formats, precision
-			output[i] = strconv.FormatFloat(f.Float(), 'f', -1, 64)
-		case reflect.Bool:
-			// Attempt to convert the value to a boolean
-			output[i] = strconv.FormatBool(f.Bool())
-		case reflect.Struct:
-			switch f.Interface().(type) {
-			case time.Time:
-				// Get the underlying time
-				t := f.Interface().(time.Time)
-
-				// Check if an alternative layout should be used
-				layout := w.layout[i]
-				if layout == "" {
-					layout = time.RFC3339
-				}
-				output[i] = t.Format(layout)
-			default:
-				return output, fmt.Errorf(
-					"csv2: unsupported struct for field %d",
-					i,
-				)
+// getStrings formats elem's fields as strings, reusing output if it is
+// already sized for w.fields to avoid a per-row allocation.
+func (w *Writer) getStrings(elem reflect.Value, output []string) ([]string, error) {
+	if len(output) != len(w.fields) {
+		output = make([]string, len(w.fields))
+	}
+	for col, field := range w.fields {
+		f, ok := fieldByIndex(elem, field.Index)
+		if !ok {
+			// field.Index passes through a nil embedded/inline struct
+			// pointer, so the field it would have held is absent.
+			output[col] = w.NullString
+			continue
+		}
+
+		if f.Kind() == reflect.Ptr {
+			// A nil pointer writes as the configured null value; a
+			// non-nil pointer formats the value it points to.
+			if f.IsNil() {
+				output[col] = w.NullString
+				continue
+			}
+			f = f.Elem()
+		} else if field.OmitEmpty && f.IsZero() {
+			output[col] = w.NullString
+			continue
+		}
+
+		s, err := w.formatScalar(f, field)
+		if err != nil {
+			return output, err
+		}
+		output[col] = s
+	}
+	return output, nil
+}
+
+// formatScalar formats f, which must be non-pointer, as a string. It is
+// used directly for ordinary fields and against the pointed-to value when
+// f belongs to a non-nil pointer field.
+func (w *Writer) formatScalar(f reflect.Value, field fieldInfo) (string, error) {
+	// time.Time is excluded here because it implements TextMarshaler
+	// itself (RFC3339 only), which would make field.Layout dead code.
+	if f.Type() != timeType {
+		if s, handled, err := marshalField(f); handled {
+			return s, err
+		}
+	}
+
+	// TODO What about using a type switch instead? benchmark it.
+	switch f.Kind() {
+	case reflect.String:
+		return f.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// TODO additional base output
+		return strconv.FormatInt(f.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(f.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		// TODO additional formats, precision
+		return strconv.FormatFloat(f.Float(), 'f', -1, f.Type().Bits()), nil
+	case reflect.Bool:
+		return strconv.FormatBool(f.Bool()), nil
+	case reflect.Struct:
+		switch f.Interface().(type) {
+		case time.Time:
+			// Get the underlying time
+			t := f.Interface().(time.Time)
+
+			// Check if an alternative layout should be used
+			layout := field.Layout
+			if layout == "" {
+				layout = time.RFC3339
 			}
+			return t.Format(layout), nil
 		default:
-			return output, fmt.Errorf(
-				"csv2: unsupported type %s for field %d",
-				f.Kind(),
-				i,
+			return "", fmt.Errorf(
+				"csv2: unsupported struct for field %s",
+				field.Name,
 			)
 		}
+	default:
+		return "", fmt.Errorf(
+			"csv2: unsupported type %s for field %s",
+			f.Kind(),
+			field.Name,
+		)
 	}
-	return output, nil
 }
 
 // Marshal writes a slice of structs to the Writer.
@@ -291,13 +718,11 @@ func (w *Writer) Marshal(i interface{}) error {
 
 	// Get the type of the slice element
 	elem := sliceValue.Type().Elem()
-
-	// Check the struct tags for any custom csv layout tags
-	w.layout = setLayout(elem)
+	w.fields = buildFieldInfo(elem)
 
 	// Read all
 	for index := 0; index < sliceValue.Len(); index += 1 {
-		s, err := w.getStrings(sliceValue.Index(index))
+		s, err := w.getStrings(sliceValue.Index(index), nil)
 		if err != nil {
 			return err
 		}
@@ -315,3 +740,58 @@ func (w *Writer) Marshal(i interface{}) error {
 func NewWriter(r io.Writer) *Writer {
 	return &Writer{Writer: csv.NewWriter(r)}
 }
+
+// Encoder writes values of a single, pre-determined struct type to a
+// Writer as CSV rows. It builds the reflected field info once, in
+// NewEncoder, and reuses one row buffer across calls, where Marshal
+// allocates a new row per element. The header row is written on the first
+// Encode call.
+// Callers are responsible for flushing the underlying Writer once done.
+type Encoder struct {
+	w           *Writer
+	row         []string
+	wroteHeader bool
+}
+
+// NewEncoder returns an Encoder that writes rows shaped like sample to w.
+// sample is only used to determine the struct type; its value is
+// otherwise ignored.
+func NewEncoder(w *Writer, sample interface{}) (*Encoder, error) {
+	t := reflect.TypeOf(sample)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, ErrNotStruct
+	}
+
+	w.fields = buildFieldInfo(t)
+	return &Encoder{w: w}, nil
+}
+
+// Encode writes v, which must be a struct or pointer to a struct of the
+// type given to NewEncoder, as the next CSV row.
+func (e *Encoder) Encode(v interface{}) error {
+	elem := reflect.Indirect(reflect.ValueOf(v))
+	if elem.Kind() != reflect.Struct {
+		return ErrNotStruct
+	}
+
+	if !e.wroteHeader {
+		headers := make([]string, len(e.w.fields))
+		for i, field := range e.w.fields {
+			headers[i] = field.Name
+		}
+		if err := e.w.Write(headers); err != nil {
+			return err
+		}
+		e.wroteHeader = true
+	}
+
+	row, err := e.w.getStrings(elem, e.row)
+	if err != nil {
+		return err
+	}
+	e.row = row
+	return e.w.Write(row)
+}
@@ -3,7 +3,10 @@ package csv
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"net"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -24,7 +27,8 @@ type country struct {
 	Freedom    bool
 }
 
-var nullExample = []byte(`2,"United States","US",317808000,17.438,1776-07-04T00:00:00Z,true
+var nullExample = []byte(`ID,Name,Abbrev,Population,GDP,Founded,Freedom
+2,"United States","US",317808000,17.438,1776-07-04T00:00:00Z,true
 ,"",,,,,`)
 
 type nullableCountry struct {
@@ -52,7 +56,8 @@ func (c country) String() string {
 	return fmt.Sprintf("%s, %s, (%d)", c.Name, c.Abbrev, c.ID)
 }
 
-var exampleHolidays = []byte(`Fourth of July,Jul 4
+var exampleHolidays = []byte(`Name,Day
+Fourth of July,Jul 4
 Halloween,Oct 31
 Thanksgiving,Nov 27`)
 
@@ -76,6 +81,66 @@ type badHolidayPointer struct {
 	Day  time.Time `csv:"Jan _2"`
 }
 
+// quarter is a custom type implementing CSVMarshaler and CSVUnmarshaler.
+type quarter int
+
+func (q quarter) MarshalCSV() (string, error) {
+	return fmt.Sprintf("Q%d", q), nil
+}
+
+func (q *quarter) UnmarshalCSV(value string) error {
+	var n int
+	if _, err := fmt.Sscanf(value, "Q%d", &n); err != nil {
+		return fmt.Errorf("csv2: invalid quarter %q", value)
+	}
+	*q = quarter(n)
+	return nil
+}
+
+// fiscalYear exercises a CSVMarshaler/CSVUnmarshaler field (Quarter) and an
+// encoding.TextMarshaler/TextUnmarshaler field (Expires, via net.IP).
+type fiscalYear struct {
+	Year    int64
+	Quarter quarter
+	Expires net.IP
+}
+
+var exampleFiscalYears = []byte(`Year,Quarter,Expires
+2024,Q3,192.168.1.1`)
+
+type address struct {
+	Street string
+	City   string
+}
+
+// user flattens the embedded address struct into its own columns.
+type user struct {
+	Name string
+	address
+}
+
+var exampleUsers = []byte(`Name,Street,City
+Alice,1 Main St,Springfield`)
+
+// billedUser flattens its address field under an explicit "Billing_" prefix.
+type billedUser struct {
+	Name    string
+	Billing address `csv:"Billing_,inline"`
+}
+
+var exampleBilledUsers = []byte(`Name,Billing_Street,Billing_City
+Bob,2 Side St,Shelbyville`)
+
+// tenant flattens a pointer-to-struct field, which is allocated on read
+// and may be left nil on write.
+type tenant struct {
+	Name    string
+	Billing *address `csv:"Billing_,inline"`
+}
+
+var exampleTenants = []byte(`Name,Billing_Street,Billing_City
+Carol,3 Back St,Capital City`)
+
 func TestGetFieldNames(t *testing.T) {
 	assert := assert.New(t)
 	expected := []string{
@@ -135,18 +200,17 @@ func TestGetFieldNames(t *testing.T) {
 	assert.Equal(ErrUnwritable, err)
 }
 
-func TestSetLayout(t *testing.T) {
+func TestBuildFieldInfo(t *testing.T) {
 	assert := assert.New(t)
 
 	// Create a buffer with CSV format and a new csv2 reader
 	r := NewReader(bytes.NewBuffer(exampleHolidays))
 
-	var h holiday
-
-	// Set the layouts
-	layout := setLayout(reflect.PtrTo(reflect.TypeOf(h)).Elem())
-	assert.Equal(1, len(layout))
-	assert.Equal("Jan _2", layout[1])
+	// The Day field's "csv" tag is reserved as a time.Parse layout
+	info := buildFieldInfo(reflect.TypeOf(holiday{}))
+	assert.Equal(2, len(info))
+	assert.Equal("Day", info[1].Name)
+	assert.Equal("Jan _2", info[1].Layout)
 
 	// Also try with an array
 	var holidays []holiday
@@ -161,30 +225,35 @@ func TestReader_Unmarshal(t *testing.T) {
 	// Create a buffer with CSV format and a new csv2 reader
 	r := NewReader(bytes.NewBuffer(example))
 
-	// Get rid of the header
-	_, err := r.Read()
-	assert.Nil(err)
-
 	// Unmarshal the whole file
 	var countries []country
 	assert.Nil(r.Unmarshal(&countries))
 	assert.Equal(2, len(countries))
+	assert.Equal(
+		[]string{"ID", "NAME", "ABBREV", "POPULATION", "GDP (trillions)", "FOUNDED", "FREEDOM?"},
+		r.Headers(),
+	)
 
 	c := countries[0]
 	assert.Equal("United States", c.Name)
 	assert.Equal("US", c.Abbrev)
-	assert.Equal(2, c.ID)
+	assert.Equal(int64(2), c.ID)
 	assert.Equal(
 		time.Date(1776, time.Month(7), 4, 0, 0, 0, 0, time.UTC),
 		c.Founded,
 	)
-	assert.Equal(17.438, c.GDP)
+	// HeaderNormalizer strips punctuation and case, so "FREEDOM?" now
+	// matches Freedom by name, but "GDP (trillions)" still carries an
+	// extra word that the GDP field's name alone doesn't account for
+	assert.Equal(0.0, c.GDP)
 	assert.Equal(true, c.Freedom)
+	assert.Equal([]string{"GDP (trillions)"}, r.MismatchedHeaders)
+	assert.Equal([]string{"GDP"}, r.MismatchedStructFields)
 
 	c = countries[1]
 	assert.Equal("Canada", c.Name)
 	assert.Equal("CA", c.Abbrev)
-	assert.Equal(3, c.ID)
+	assert.Equal(int64(3), c.ID)
 
 	// Unmarshal a struct will pointer fields
 	r = NewReader(bytes.NewBuffer(nullExample))
@@ -196,7 +265,7 @@ func TestReader_Unmarshal(t *testing.T) {
 	nc = nullableCountries[0]
 	assert.Equal("United States", nc.Name)
 	assert.Equal("US", nc.Abbrev)
-	assert.Equal(2, *nc.ID)
+	assert.Equal(int64(2), *nc.ID)
 	assert.Equal(
 		time.Date(1776, time.Month(7), 4, 0, 0, 0, 0, time.UTC),
 		*nc.Founded,
@@ -244,22 +313,74 @@ func TestReader_Unmarshal(t *testing.T) {
 	assert.NotNil(r.Unmarshal(&badHolidayPointers))
 }
 
+// countryUntagged matches the example fixture's headers with no csv tags
+// at all, relying entirely on MatchHeader.
+type countryUntagged struct {
+	ID         int64
+	Name       string
+	Abbrev     string
+	Population int64
+	GDP        float64
+	Founded    time.Time
+	Freedom    bool
+}
+
+func TestReader_MatchHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	// MatchHeader overrides HeaderNormalizer entirely
+	r := NewReader(bytes.NewBuffer(example))
+	r.MatchHeader = func(csvCol, structField string) bool {
+		switch csvCol {
+		case "GDP (trillions)":
+			return structField == "GDP"
+		case "FREEDOM?":
+			return structField == "Freedom"
+		default:
+			return strings.EqualFold(csvCol, structField)
+		}
+	}
+
+	var countries []countryUntagged
+	assert.Nil(r.Unmarshal(&countries))
+	assert.Equal(17.438, countries[0].GDP)
+	assert.Equal(true, countries[0].Freedom)
+	assert.Empty(r.MismatchedHeaders)
+	assert.Empty(r.MismatchedStructFields)
+}
+
+// countryTrillions tags GDP to match "GDP (trillions)" once normalized.
+type countryTrillions struct {
+	ID      int64
+	GDP     float64 `csv:"GDP (trillions)"`
+	Freedom bool
+}
+
+func TestReader_HeaderNormalizer(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewReader(bytes.NewBuffer(example))
+
+	var countries []countryTrillions
+	assert.Nil(r.Unmarshal(&countries))
+	assert.Equal(17.438, countries[0].GDP)
+	assert.Equal(true, countries[0].Freedom)
+	assert.Equal([]string{"NAME", "ABBREV", "POPULATION", "FOUNDED"}, r.MismatchedHeaders)
+	assert.Empty(r.MismatchedStructFields)
+}
+
 func TestReader_UnmarshalOne(t *testing.T) {
 	assert := assert.New(t)
 
 	// Create a buffer with CSV format and a new csv2 reader
 	r := NewReader(bytes.NewBuffer(example))
 
-	// Get rid of the header
-	_, err := r.Read()
-	assert.Nil(err)
-
 	// Unmarshal one row
 	var c country
 	assert.Nil(r.UnmarshalOne(&c))
 	assert.Equal("United States", c.Name)
 	assert.Equal("US", c.Abbrev)
-	assert.Equal(2, c.ID)
+	assert.Equal(int64(2), c.ID)
 	assert.Equal(
 		time.Date(1776, time.Month(7), 4, 0, 0, 0, 0, time.UTC),
 		c.Founded,
@@ -276,6 +397,149 @@ func TestReader_UnmarshalOne(t *testing.T) {
 	assert.Equal(ErrNotStruct, r.UnmarshalOne(&i))
 }
 
+func TestCustomMarshaler(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewReader(bytes.NewBuffer(exampleFiscalYears))
+	var years []fiscalYear
+	assert.Nil(r.Unmarshal(&years))
+	assert.Equal(1, len(years))
+	assert.Equal(int64(2024), years[0].Year)
+	assert.Equal(quarter(3), years[0].Quarter)
+	assert.Equal("192.168.1.1", years[0].Expires.String())
+
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	assert.Nil(w.WriteHeader(&years))
+	assert.Nil(w.Marshal(&years))
+	assert.Equal("Year,Quarter,Expires\n2024,Q3,192.168.1.1\n", b.String())
+}
+
+// numericRow exercises the full range of Go numeric kinds.
+type numericRow struct {
+	A int
+	B uint32
+	C float32
+}
+
+var exampleNumericRows = []byte(`A,B,C
+-7,42,3.5
+0,0,0`)
+
+func TestNumericKinds(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewReader(bytes.NewBuffer(exampleNumericRows))
+	var rows []numericRow
+	assert.Nil(r.Unmarshal(&rows))
+	assert.Equal(2, len(rows))
+	assert.Equal(numericRow{-7, 42, 3.5}, rows[0])
+	assert.Equal(numericRow{0, 0, 0}, rows[1])
+
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	assert.Nil(w.WriteHeader(&rows))
+	assert.Nil(w.Marshal(&rows))
+	assert.Equal(string(exampleNumericRows)+"\n", b.String())
+}
+
+func TestDecoder(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewReader(bytes.NewBuffer(exampleNumericRows))
+	d, err := NewDecoder(r, numericRow{})
+	assert.Nil(err)
+
+	var rows []numericRow
+	for {
+		var row numericRow
+		if err := d.Decode(&row); err != nil {
+			assert.Equal(io.EOF, err)
+			break
+		}
+		rows = append(rows, row)
+	}
+	assert.Equal(2, len(rows))
+	assert.Equal(numericRow{-7, 42, 3.5}, rows[0])
+	assert.Equal([]string{"0", "0", "0"}, d.Record())
+}
+
+func TestEncoder(t *testing.T) {
+	assert := assert.New(t)
+
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	e, err := NewEncoder(w, numericRow{})
+	assert.Nil(err)
+
+	assert.Nil(e.Encode(numericRow{-7, 42, 3.5}))
+	assert.Nil(e.Encode(numericRow{0, 0, 0}))
+	w.Flush()
+	assert.Equal(string(exampleNumericRows)+"\n", b.String())
+}
+
+func TestEmbeddedStruct(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewReader(bytes.NewBuffer(exampleUsers))
+	var users []user
+	assert.Nil(r.Unmarshal(&users))
+	assert.Equal(1, len(users))
+	assert.Equal("Alice", users[0].Name)
+	assert.Equal("1 Main St", users[0].Street)
+	assert.Equal("Springfield", users[0].City)
+
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	assert.Nil(w.WriteHeader(&users))
+	assert.Nil(w.Marshal(&users))
+	assert.Equal(string(exampleUsers)+"\n", b.String())
+}
+
+func TestInlineStruct(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewReader(bytes.NewBuffer(exampleBilledUsers))
+	var users []billedUser
+	assert.Nil(r.Unmarshal(&users))
+	assert.Equal(1, len(users))
+	assert.Equal("Bob", users[0].Name)
+	assert.Equal("2 Side St", users[0].Billing.Street)
+	assert.Equal("Shelbyville", users[0].Billing.City)
+
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	assert.Nil(w.WriteHeader(&users))
+	assert.Nil(w.Marshal(&users))
+	assert.Equal(string(exampleBilledUsers)+"\n", b.String())
+}
+
+func TestInlinePointerStruct(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewReader(bytes.NewBuffer(exampleTenants))
+	var tenants []tenant
+	assert.Nil(r.Unmarshal(&tenants))
+	assert.Equal(1, len(tenants))
+	assert.Equal("Carol", tenants[0].Name)
+	assert.Equal("3 Back St", tenants[0].Billing.Street)
+	assert.Equal("Capital City", tenants[0].Billing.City)
+
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	assert.Nil(w.WriteHeader(&tenants))
+	assert.Nil(w.Marshal(&tenants))
+	assert.Equal(string(exampleTenants)+"\n", b.String())
+
+	// A nil Billing writes its flattened fields as the null value
+	var empty bytes.Buffer
+	w = NewWriter(&empty)
+	nilBilled := []tenant{{Name: "Dan"}}
+	assert.Nil(w.WriteHeader(&nilBilled))
+	assert.Nil(w.Marshal(&nilBilled))
+	assert.Equal("Name,Billing_Street,Billing_City\nDan,,\n", empty.String())
+}
+
 func TestWriter(t *testing.T) {
 	assert := assert.New(t)
 
@@ -289,3 +553,63 @@ func TestWriter(t *testing.T) {
 	assert.Nil(w.Marshal(&typedCountries))
 	assert.Equal(expectedCountries, b.String())
 }
+
+func TestWriter_Pointers(t *testing.T) {
+	assert := assert.New(t)
+
+	// Round-trip the pointer fields read in TestReader_Unmarshal
+	r := NewReader(bytes.NewBuffer(nullExample))
+	var nullableCountries []nullableCountry
+	assert.Nil(r.Unmarshal(&nullableCountries))
+
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	assert.Nil(w.WriteHeader(&nullableCountries))
+	assert.Nil(w.Marshal(&nullableCountries))
+	assert.Equal(`ID,Name,Abbrev,Population,GDP,Founded,Freedom
+2,United States,US,317808000,17.438,1776-07-04T00:00:00Z,true
+,,,,,,
+`, b.String())
+
+	// A configurable NullString is used for nil pointers
+	type nilableAmount struct {
+		Label  string
+		Amount *float64
+	}
+	rows := []nilableAmount{{Label: "Coffee", Amount: nil}}
+
+	b.Reset()
+	w = NewWriter(&b)
+	w.NullString = `\N`
+	assert.Nil(w.WriteHeader(&rows))
+	assert.Nil(w.Marshal(&rows))
+	assert.Equal("Label,Amount\nCoffee,\\N\n", b.String())
+}
+
+var exampleLedger = []byte(`label,amount
+Coffee,4.5
+,`)
+
+type ledgerEntry struct {
+	Label  string  `csv:"label,omitempty"`
+	Amount float64 `csv:"amount,omitempty"`
+}
+
+func TestOmitEmpty(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewReader(bytes.NewBuffer(exampleLedger))
+	var entries []ledgerEntry
+	assert.Nil(r.Unmarshal(&entries))
+	assert.Equal(2, len(entries))
+	assert.Equal(ledgerEntry{"Coffee", 4.5}, entries[0])
+	assert.Equal(ledgerEntry{"", 0}, entries[1])
+
+	// A zero-valued "omitempty" field writes back as NullString
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	w.NullString = "NULL"
+	assert.Nil(w.WriteHeader(&entries))
+	assert.Nil(w.Marshal(&entries))
+	assert.Equal("label,amount\nCoffee,4.5\nNULL,NULL\n", b.String())
+}